@@ -14,21 +14,36 @@ import "sort"
 import "sync"
 import "flag"
 import "path/filepath"
+import "net"
+import "net/http"
+import "bytes"
+import "crypto/tls"
+import "encoding/hex"
 
 import mdns "github.com/miekg/dns"
 
-const version = "v0.1-13"
+const version = "v0.1-22"
 
 type DnsAddr struct {
     Name string
     Address string
+    Port uint16 `json:"port,omitempty"`
+    Proto string `json:"proto,omitempty"`
+    TlsServerName string `json:"tls_servername,omitempty"`
+    Subnet string `json:"subnet,omitempty"`
+    Nsid bool `json:"nsid,omitempty"`
 }
 
 type DnsAddrs []DnsAddr
 
 type Answer struct {
     DnsAddr
-    IP []string
+    Type string
+    Records []string
+    NSID string
+    AD bool
+    Validated bool
+    DnssecError string
     Error error
 }
 
@@ -36,7 +51,12 @@ type Answers []Answer
 
 type AnswerJson struct {
     DnsAddr
-    IP []string
+    Type string
+    Records []string
+    NSID string
+    AD bool
+    Validated bool
+    DnssecError string
     Error string
 }
 
@@ -54,55 +74,564 @@ func (e *ReadConfigError) Error() string {
 
 var appname string
 
+// recordTypes maps the names accepted by the "-type" flag to their
+// miekg/dns query type constants.
+var recordTypes = map[string]uint16{
+    "A":     mdns.TypeA,
+    "AAAA":  mdns.TypeAAAA,
+    "MX":    mdns.TypeMX,
+    "TXT":   mdns.TypeTXT,
+    "NS":    mdns.TypeNS,
+    "SOA":   mdns.TypeSOA,
+    "CNAME": mdns.TypeCNAME,
+    "PTR":   mdns.TypePTR,
+    "CAA":   mdns.TypeCAA,
+}
+
+// typeName returns the "-type" flag name for a query type, defaulting to "A".
+func typeName(qtype uint16) string {
+    for name, t := range recordTypes {
+        if t == qtype {
+            return name
+        }
+    }
+    return "A"
+}
+
+// rrToString renders a resource record as a type-appropriate display string.
+// It returns "" if rr doesn't match the requested qtype.
+func rrToString(rr mdns.RR, qtype uint16) string {
+    switch t := rr.(type) {
+    case *mdns.A:
+        if qtype == mdns.TypeA {
+            return t.A.String()
+        }
+    case *mdns.AAAA:
+        if qtype == mdns.TypeAAAA {
+            return t.AAAA.String()
+        }
+    case *mdns.MX:
+        if qtype == mdns.TypeMX {
+            return fmt.Sprintf("%d %s", t.Preference, t.Mx)
+        }
+    case *mdns.TXT:
+        if qtype == mdns.TypeTXT {
+            return fmt.Sprintf("%q", strings.Join(t.Txt, " "))
+        }
+    case *mdns.NS:
+        if qtype == mdns.TypeNS {
+            return t.Ns
+        }
+    case *mdns.SOA:
+        if qtype == mdns.TypeSOA {
+            return fmt.Sprintf("%s %s %d %d %d %d %d", t.Ns, t.Mbox, t.Serial, t.Refresh, t.Retry, t.Expire, t.Minttl)
+        }
+    case *mdns.CNAME:
+        if qtype == mdns.TypeCNAME {
+            return t.Target
+        }
+    case *mdns.PTR:
+        if qtype == mdns.TypePTR {
+            return t.Ptr
+        }
+    case *mdns.CAA:
+        if qtype == mdns.TypeCAA {
+            return fmt.Sprintf("%d %s %q", t.Flag, t.Tag, t.Value)
+        }
+    }
+    return ""
+}
+
+
+// Edns0Options carries the per-query EDNS0 options used to compare CDN
+// steering: a client subnet to send (EDNS0_SUBNET) and whether to request
+// the responder's NSID (EDNS0_NSID).
+type Edns0Options struct {
+    Subnet string
+    Nsid bool
+}
+
+// setEdns0 attaches an OPT record with the requested options (and, when
+// dnssec is true, the DO bit) to msg. It's a no-op if nothing was requested.
+func setEdns0(msg *mdns.Msg, edns Edns0Options, dnssec bool) error {
+    if edns.Subnet == "" && !edns.Nsid && !dnssec {
+        return nil
+    }
+
+    msg.SetEdns0(4096, dnssec)
+    opt := msg.IsEdns0()
+
+    if edns.Subnet != "" {
+        ip, ipnet, err := net.ParseCIDR(edns.Subnet)
+        if err != nil {
+            return err
+        }
+        ones, _ := ipnet.Mask.Size()
+
+        e := new(mdns.EDNS0_SUBNET)
+        e.Code = mdns.EDNS0SUBNET
+        e.SourceNetmask = uint8(ones)
+        e.SourceScope = 0
+        if ip4 := ip.To4(); ip4 != nil {
+            e.Family = 1
+            e.Address = ip4
+        } else {
+            e.Family = 2
+            e.Address = ip
+        }
+        opt.Option = append(opt.Option, e)
+    }
+
+    if edns.Nsid {
+        e := new(mdns.EDNS0_NSID)
+        e.Code = mdns.EDNS0NSID
+        opt.Option = append(opt.Option, e)
+    }
+
+    return nil
+}
+
+
+// nsidFromMsg extracts the NSID option from a response's OPT record, if
+// present, decoding it to a printable string when possible.
+func nsidFromMsg(r *mdns.Msg) string {
+    opt := r.IsEdns0()
+    if opt == nil {
+        return ""
+    }
+
+    for _, o := range opt.Option {
+        if n, ok := o.(*mdns.EDNS0_NSID); ok {
+            if b, err := hex.DecodeString(n.Nsid); err == nil {
+                return string(b)
+            }
+            return n.Nsid
+        }
+    }
+
+    return ""
+}
+
+
+// defaultPort returns the conventional port for a transport when the
+// configuration doesn't specify one.
+func defaultPort(proto string) uint16 {
+    switch proto {
+    case "tcp-tls":
+        return 853
+    case "https":
+        return 443
+    default:
+        return 53
+    }
+}
+
+
+// QueryResult carries everything a single resolver's reply can contribute:
+// the type-appropriate record strings, an NSID (if requested/returned), the
+// AD bit, and the raw answer RRs (needed for DNSSEC validation).
+type QueryResult struct {
+    Records []string
+    NSID string
+    AD bool
+    Answer []mdns.RR
+}
+
+
 /* from qlibgo/dns
 
-Get a domain's IPs from a specific name server.
+Get a domain's records of a given type from a specific name server.
 
 Parameters:
-    domain      the domain you want to query
-    nameserver  name server's IP address
-    port        53 in general
-    net         tcp or udp
-    timeout     in seconds, can be omitted
+    domain          the domain you want to query
+    qtype           record type, e.g. mdns.TypeA, mdns.TypeMX
+    nameserver      name server's IP address (or DoH endpoint when proto is "https")
+    port            53 in general, ignored when proto is "https"
+    proto           udp, tcp, tcp-tls (DoT) or https (DoH)
+    tlsServerName   expected TLS certificate name, used with "tcp-tls" (can be empty)
+    edns            EDNS0 client subnet / NSID options to attach to the query
+    dnssec          set the DO bit, requesting DNSSEC records in the reply
+    timeout         in seconds, can be omitted
 
 Here's an example：
-    r, e := ARecords("www.example.com", "8.8.8.8", 53, "tcp")
+    r, e := Records("www.example.com", mdns.TypeA, "8.8.8.8", 53, "tcp", "", Edns0Options{}, false)
     if e != nil {
         fmt.Println(e)
     } else {
-        fmt.Println(r)
+        fmt.Println(r.Records)
     }
 */
-func ARecords(domain, nameserver string, port uint16, net string, timeout ...uint8) ([]string, error) {
-    var result []string
+func Records(domain string, qtype uint16, nameserver string, port uint16, proto string, tlsServerName string, edns Edns0Options, dnssec bool, timeout ...uint8) (QueryResult, error) {
+
+    if proto == "https" {
+        return recordsDoH(domain, qtype, nameserver, edns, dnssec, timeout...)
+    }
 
-    if net != "tcp" && net != "udp" {
-        return result, errors.New("The Parameter 'net' should only be 'tcp' or 'udp'.")
+    if proto != "udp" && proto != "tcp" && proto != "tcp-tls" {
+        return QueryResult{}, errors.New("The Parameter 'proto' should only be 'udp', 'tcp', 'tcp-tls' or 'https'.")
     }
 
     msg := new(mdns.Msg)
-    msg.SetQuestion(mdns.Fqdn(domain), mdns.TypeA)
+    msg.SetQuestion(mdns.Fqdn(domain), qtype)
+    if err := setEdns0(msg, edns, dnssec); err != nil {
+        return QueryResult{}, err
+    }
 
-    var client *mdns.Client
+    client := &mdns.Client{Net: proto}
     if len(timeout) > 0 {
         tm := time.Duration(timeout[0]) * time.Second
-        client = &mdns.Client { Net: net, DialTimeout: tm, ReadTimeout: tm, WriteTimeout: tm }
-    } else {
-        client = &mdns.Client { Net: net }
+        client.DialTimeout, client.ReadTimeout, client.WriteTimeout = tm, tm, tm
+    }
+    if proto == "tcp-tls" && tlsServerName != "" {
+        client.TLSConfig = &tls.Config{ServerName: tlsServerName}
     }
 
     r, _, err := client.Exchange(msg, fmt.Sprintf("%s:%d", nameserver, port))
     if err != nil {
-        return result, err
+        return QueryResult{}, err
+    }
+
+    return toQueryResult(r, qtype), nil
+}
+
+
+// recordsDoH performs a DNS-over-HTTPS query (RFC 8484), POSTing the packed
+// wire-format message to endpoint and unpacking the wire-format response.
+// endpoint may be a bare host (assumed to serve "/dns-query" over https) or
+// a full URL.
+func recordsDoH(domain string, qtype uint16, endpoint string, edns Edns0Options, dnssec bool, timeout ...uint8) (QueryResult, error) {
+
+    msg := new(mdns.Msg)
+    msg.SetQuestion(mdns.Fqdn(domain), qtype)
+    if err := setEdns0(msg, edns, dnssec); err != nil {
+        return QueryResult{}, err
+    }
+
+    packed, err := msg.Pack()
+    if err != nil {
+        return QueryResult{}, err
+    }
+
+    url := endpoint
+    if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+        url = fmt.Sprintf("https://%s/dns-query", endpoint)
     }
 
+    req, err := http.NewRequest("POST", url, bytes.NewReader(packed))
+    if err != nil {
+        return QueryResult{}, err
+    }
+    req.Header.Set("Content-Type", "application/dns-message")
+    req.Header.Set("Accept", "application/dns-message")
+
+    client := &http.Client{}
+    if len(timeout) > 0 {
+        client.Timeout = time.Duration(timeout[0]) * time.Second
+    }
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return QueryResult{}, err
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return QueryResult{}, err
+    }
+
+    r := new(mdns.Msg)
+    if err := r.Unpack(body); err != nil {
+        return QueryResult{}, err
+    }
+
+    return toQueryResult(r, qtype), nil
+}
+
+
+// toQueryResult extracts the type-appropriate record strings, NSID, AD bit
+// and raw answer RRs out of a reply.
+func toQueryResult(r *mdns.Msg, qtype uint16) QueryResult {
+    var result []string
     for _, i := range r.Answer {
-        if t, ok := i.(*mdns.A); ok {
-            result = append(result, t.A.String())
+        if s := rrToString(i, qtype); s != "" {
+            result = append(result, s)
         }
     }
+    return QueryResult{Records: result, NSID: nsidFromMsg(r), AD: r.AuthenticatedData, Answer: r.Answer}
+}
 
-    return result, nil
+
+// rootTrustAnchor is the DS record for the root zone's current KSK
+// (KSK-2017, key tag 20326, algorithm 8, digest type 2 / SHA-256), taken
+// from IANA's root-anchors.xml. "-dnssec" compares against it only when the
+// RRSIG's signer is the root zone itself; for any other zone the DS comes
+// from the resolver and the chain is not followed up to this anchor.
+const rootTrustAnchor = ". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+
+// exchangeRR queries nameserver for qtype over proto (udp, tcp, tcp-tls or
+// https) and returns the raw answer RRs. Used for the DNSSEC support records
+// (DNSKEY, DS) that rrToString doesn't render. It goes through Records so
+// the DNSKEY/DS lookups use the same transport as the record being
+// validated, rather than silently falling back to UDP when that transport
+// is blocked or unsupported.
+func exchangeRR(name string, qtype uint16, nameserver string, port uint16, proto string, tlsServerName string) ([]mdns.RR, error) {
+    r, err := Records(name, qtype, nameserver, port, proto, tlsServerName, Edns0Options{}, true, 3)
+    if err != nil {
+        return nil, err
+    }
+    return r.Answer, nil
+}
+
+
+/*
+validateDnssec checks the RRSIG covering qtype in answer against the
+signing zone's DNSKEY, then chains that DNSKEY to a DS: either the bundled
+rootTrustAnchor (if the signing zone is the root) or a DS fetched from the
+parent zone. nameserver/port/proto/tlsServerName say where to fetch the
+DNSKEY/DS records from. Only this one link is checked: the RRSIG over the
+fetched DS RRset is not verified, and the parent's own keys are not
+chained further up.
+
+It returns validated=true only if every step succeeds. If there's nothing
+to check (e.g. the zone isn't signed, or the resolver didn't return an
+RRSIG), or no matching DS is found, it returns a zero value: not validated,
+but with no error either (secStatus shows this as insecure, or as
+indeterminate when the resolver set the AD bit). A non-empty errmsg means
+a chain step actively failed (bogus).
+*/
+func validateDnssec(qtype uint16, answer []mdns.RR, nameserver string, port uint16, proto string, tlsServerName string) (validated bool, errmsg string) {
+    var rrsig *mdns.RRSIG
+    var covered []mdns.RR
+    for _, rr := range answer {
+        if s, ok := rr.(*mdns.RRSIG); ok && s.TypeCovered == qtype {
+            rrsig = s
+        }
+        if rr.Header().Rrtype == qtype {
+            covered = append(covered, rr)
+        }
+    }
+    if rrsig == nil || len(covered) == 0 {
+        return false, ""
+    }
+
+    zone := rrsig.SignerName
+    keyRRs, err := exchangeRR(zone, mdns.TypeDNSKEY, nameserver, port, proto, tlsServerName)
+    if err != nil {
+        return false, fmt.Sprintf("fetching DNSKEY for %s: %s", zone, err)
+    }
+
+    var signingKey *mdns.DNSKEY
+    for _, rr := range keyRRs {
+        if k, ok := rr.(*mdns.DNSKEY); ok && k.KeyTag() == rrsig.KeyTag {
+            signingKey = k
+            break
+        }
+    }
+    if signingKey == nil {
+        return false, fmt.Sprintf("no DNSKEY with tag %d in zone %s", rrsig.KeyTag, zone)
+    }
+
+    if err := rrsig.Verify(signingKey, covered); err != nil {
+        return false, fmt.Sprintf("RRSIG verification failed: %s", err)
+    }
+
+    var wantDS *mdns.DS
+    if zone == "." {
+        rr, err := mdns.NewRR(rootTrustAnchor)
+        if err != nil {
+            return false, fmt.Sprintf("parsing bundled root trust anchor: %s", err)
+        }
+        wantDS, _ = rr.(*mdns.DS)
+    } else {
+        // The DS RRset is owned by the child zone itself (e.g. "DS
+        // example.com."), even though it's served by the parent's
+        // servers — that's what makes the parent's signature over it
+        // authoritative. Querying the parent zone's own name here would
+        // return the parent's own DS record, which (for any realistic
+        // two-plus label zone) carries a different key tag and would
+        // never match.
+        dsRRs, err := exchangeRR(zone, mdns.TypeDS, nameserver, port, proto, tlsServerName)
+        if err != nil {
+            return false, fmt.Sprintf("fetching DS for %s: %s", zone, err)
+        }
+        for _, rr := range dsRRs {
+            if d, ok := rr.(*mdns.DS); ok && d.KeyTag == rrsig.KeyTag {
+                wantDS = d
+                break
+            }
+        }
+    }
+
+    if wantDS == nil {
+        // The signature checks out, but there's no DS to anchor it to
+        // (e.g. the parent doesn't delegate DNSSEC here): not validated,
+        // no error.
+        return false, ""
+    }
+
+    gotDS := signingKey.ToDS(wantDS.DigestType)
+    if gotDS == nil || !strings.EqualFold(gotDS.Digest, wantDS.Digest) {
+        return false, "DS digest mismatch between parent and DNSKEY"
+    }
+
+    return true, ""
+}
+
+
+// rootHints are the IPv4 addresses of the 13 root name servers, used as the
+// starting point for "-trace" iterative resolution.
+var rootHints = []string{
+    "198.41.0.4",     // a.root-servers.net
+    "199.9.14.201",   // b.root-servers.net
+    "192.33.4.12",    // c.root-servers.net
+    "199.7.91.13",    // d.root-servers.net
+    "192.203.230.10", // e.root-servers.net
+    "192.5.5.241",    // f.root-servers.net
+    "192.112.36.4",   // g.root-servers.net
+    "198.97.190.53",  // h.root-servers.net
+    "192.36.148.17",  // i.root-servers.net
+    "192.58.128.30",  // j.root-servers.net
+    "193.0.14.129",   // k.root-servers.net
+    "199.7.83.42",    // l.root-servers.net
+    "202.12.27.33",   // m.root-servers.net
+}
+
+// maxTraceDepth caps the number of hops "-trace" will follow, to avoid
+// looping forever on a misconfigured or malicious delegation chain.
+const maxTraceDepth = 20
+
+// exchangeAny sends msg to each of servers in turn (port 53) and returns
+// the first successful response, along with which server answered and how
+// long it took.
+func exchangeAny(msg *mdns.Msg, servers []string, proto string) (*mdns.Msg, string, time.Duration, error) {
+    client := &mdns.Client{Net: proto, DialTimeout: 3 * time.Second, ReadTimeout: 3 * time.Second, WriteTimeout: 3 * time.Second}
+
+    var lastErr error
+    for _, s := range servers {
+        r, rtt, err := client.Exchange(msg, fmt.Sprintf("%s:53", s))
+        if err != nil {
+            lastErr = err
+            continue
+        }
+        return r, s, rtt, nil
+    }
+
+    if lastErr == nil {
+        lastErr = errors.New("no name servers to query")
+    }
+    return nil, "", 0, lastErr
+}
+
+
+// glueFor collects the A/AAAA glue records in extra, keyed by owner name.
+func glueFor(extra []mdns.RR) map[string][]string {
+    glue := make(map[string][]string)
+    for _, rr := range extra {
+        switch t := rr.(type) {
+        case *mdns.A:
+            glue[t.Header().Name] = append(glue[t.Header().Name], t.A.String())
+        case *mdns.AAAA:
+            glue[t.Header().Name] = append(glue[t.Header().Name], t.AAAA.String())
+        }
+    }
+    return glue
+}
+
+
+/*
+trace performs an iterative resolution of domain, starting from the root
+hints, in the style of "dig +trace": it queries a server for qtype with
+RecursionDesired=false, follows NS/glue referrals from the Authority and
+Additional sections, restarts on CNAME chains, and prints each hop as it
+happens. The final answer's records are printed once reached.
+
+proto should be "tcp" or "udp"; other values are treated as "udp".
+*/
+func trace(domain string, qtype uint16, proto string) error {
+    if proto != "tcp" {
+        proto = "udp"
+    }
+
+    target := mdns.Fqdn(domain)
+    servers := rootHints
+    zone := "."
+
+    for depth := 0; depth < maxTraceDepth; depth++ {
+        msg := new(mdns.Msg)
+        msg.SetQuestion(target, qtype)
+        msg.RecursionDesired = false
+
+        r, ns, rtt, err := exchangeAny(msg, servers, proto)
+        if err != nil {
+            return fmt.Errorf("querying zone %s: %s", zone, err)
+        }
+
+        fmt.Printf("zone=%-20s ns=%-16s rtt=%-10s rcode=%-10s answers=%d authority=%d additional=%d\n",
+            zone, ns, rtt, mdns.RcodeToString[r.Rcode], len(r.Answer), len(r.Ns), len(r.Extra))
+
+        if len(r.Answer) > 0 {
+            var cname string
+            if qtype != mdns.TypeCNAME {
+                for _, a := range r.Answer {
+                    if c, ok := a.(*mdns.CNAME); ok {
+                        cname = c.Target
+                    }
+                }
+            }
+            if cname != "" && cname != target {
+                target = mdns.Fqdn(cname)
+                servers = rootHints
+                zone = "."
+                continue
+            }
+
+            for _, a := range r.Answer {
+                if s := rrToString(a, qtype); s != "" {
+                    fmt.Println(s)
+                }
+            }
+            return nil
+        }
+
+        var nsNames []string
+        for _, a := range r.Ns {
+            if n, ok := a.(*mdns.NS); ok {
+                nsNames = append(nsNames, n.Ns)
+                zone = n.Header().Name
+            }
+        }
+        if len(nsNames) == 0 {
+            return errors.New("no answer and no referral received")
+        }
+
+        glue := glueFor(r.Extra)
+        var next []string
+        for _, n := range nsNames {
+            next = append(next, glue[n]...)
+        }
+
+        if len(next) == 0 {
+            // No glue in the referral: ask the first root server for an A
+            // record of each NS name in turn. This is one direct query, not
+            // an iterative resolution, so it only finds names the root
+            // server answers for itself.
+            for _, n := range nsNames {
+                res, err := Records(n, mdns.TypeA, rootHints[0], 53, proto, "", Edns0Options{}, false, 3)
+                if err == nil && len(res.Records) > 0 {
+                    next = res.Records
+                    break
+                }
+            }
+        }
+        if len(next) == 0 {
+            return fmt.Errorf("could not resolve name servers for zone %s", zone)
+        }
+
+        servers = next
+    }
+
+    return errors.New("trace exceeded max depth")
 }
 
 
@@ -110,11 +639,18 @@ func ARecords(domain, nameserver string, port uint16, net string, timeout ...uin
 Use goroutines to query one domain with multiple name servers.
 
 Parameters:
-    dns     name server configuration
-    domain  the domain you want to query
-    net     tcp or udp
+    dns           name server configuration
+    domain        the domain you want to query
+    qtype         record type to query, e.g. mdns.TypeA, mdns.TypeMX
+    defaultProto  udp, tcp, tcp-tls or https; used for any entry that doesn't
+                  specify its own "proto"
+    defaultEdns   EDNS0 subnet/NSID options; an entry's own "subnet" replaces
+                  the subnet, and an entry's "nsid": true turns NSID on
+                  (an entry can't turn it off)
+    dnssec        set the DO bit and validate the RRSIG/DNSKEY/DS chain
+                  covering the answer
 */
-func query(dns DnsAddrs, domain string, net string) Answers {
+func query(dns DnsAddrs, domain string, qtype uint16, defaultProto string, defaultEdns Edns0Options, dnssec bool) Answers {
     var wg sync.WaitGroup
     answers := make(Answers, len(dns))
     for j, i := range dns {
@@ -123,44 +659,223 @@ func query(dns DnsAddrs, domain string, net string) Answers {
             defer wg.Done()
             var answer Answer
             answer.DnsAddr = d
-            ip, err := ARecords(domain, d.Address, 53, net, 3)
+            answer.Type = typeName(qtype)
+
+            proto := d.Proto
+            if proto == "" {
+                proto = defaultProto
+            }
+            port := d.Port
+            if port == 0 {
+                port = defaultPort(proto)
+            }
+
+            edns := defaultEdns
+            if d.Subnet != "" {
+                edns.Subnet = d.Subnet
+            }
+            if d.Nsid {
+                edns.Nsid = true
+            }
+
+            res, err := Records(domain, qtype, d.Address, port, proto, d.TlsServerName, edns, dnssec, 3)
             if err != nil {
                 answer.Error = err
             } else {
-                if len(ip) == 0 {
+                if len(res.Records) == 0 {
                     answer.Error = errors.New("No result")
                 } else {
-                    answer.IP = ip
+                    answer.Records = res.Records
+                }
+                answer.AD = res.AD
+                if dnssec {
+                    answer.Validated, answer.DnssecError = validateDnssec(qtype, res.Answer, d.Address, port, proto, d.TlsServerName)
                 }
             }
+            answer.NSID = res.NSID
             answers[n] = answer
         }(j, i)
     }
-    
+
     wg.Wait()
     return answers
 }
 
 
-// Get all the IPs from the query results.
-func (a Answers) allIP() []string {
+// Get all the distinct records from the query results.
+func (a Answers) allRecords() []string {
 
-    var ips []string
+    var recs []string
     i := make(map[string]bool)
 
     for _, item := range a {
-        for _, ip := range item.IP {
-            i[ip] = true
+        for _, r := range item.Records {
+            i[r] = true
         }
     }
 
     for key, _ := range i {
-        ips = append(ips, key)
+        recs = append(recs, key)
+    }
+
+    sort.Strings(recs)
+    return recs
+
+}
+
+
+// diffStrings returns the elements of a that aren't in b.
+func diffStrings(a, b []string) []string {
+    inB := make(map[string]bool, len(b))
+    for _, s := range b {
+        inB[s] = true
+    }
+    var out []string
+    for _, s := range a {
+        if !inB[s] {
+            out = append(out, s)
+        }
+    }
+    return out
+}
+
+
+// sameRecords reports whether a and b contain the same records, ignoring
+// order.
+func sameRecords(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    as := append([]string(nil), a...)
+    bs := append([]string(nil), b...)
+    sort.Strings(as)
+    sort.Strings(bs)
+    for i := range as {
+        if as[i] != bs[i] {
+            return false
+        }
+    }
+    return true
+}
+
+
+// Equal reports whether two result sets carry the same records for every
+// resolver, matched by name+address and ignoring record order. Used by
+// "-watch" to detect whether a cycle changed anything.
+func (a Answers) Equal(prev Answers) bool {
+    if len(a) != len(prev) {
+        return false
+    }
+
+    byKey := make(map[string]Answer, len(prev))
+    for _, p := range prev {
+        byKey[p.Name + "|" + p.Address] = p
+    }
+
+    for _, cur := range a {
+        p, ok := byKey[cur.Name + "|" + cur.Address]
+        if !ok || !sameRecords(cur.Records, p.Records) {
+            return false
+        }
+    }
+    return true
+}
+
+
+// Event describes one resolver's records changing between two "-watch"
+// cycles.
+type Event struct {
+    Time string `json:"time"`
+    Name string `json:"name"`
+    Address string `json:"address"`
+    Added []string `json:"added,omitempty"`
+    Removed []string `json:"removed,omitempty"`
+}
+
+
+// diffEvents compares cur against prev (matched by name+address) and
+// returns one Event per resolver whose record set changed.
+func diffEvents(prev, cur Answers) []Event {
+    byKey := make(map[string]Answer, len(prev))
+    for _, p := range prev {
+        byKey[p.Name + "|" + p.Address] = p
+    }
+
+    now := time.Now().Format(time.RFC3339)
+
+    var events []Event
+    for _, c := range cur {
+        p, ok := byKey[c.Name + "|" + c.Address]
+
+        var added, removed []string
+        if !ok {
+            added = c.Records
+        } else if !sameRecords(c.Records, p.Records) {
+            added = diffStrings(c.Records, p.Records)
+            removed = diffStrings(p.Records, c.Records)
+        }
+
+        if len(added) > 0 || len(removed) > 0 {
+            events = append(events, Event{Time: now, Name: c.Name, Address: c.Address, Added: added, Removed: removed})
+        }
     }
+    return events
+}
 
-    sort.Strings(ips)
-    return ips
 
+// printEvents writes one JSON-encoded Event per line to stdout, so "-watch"
+// output can be piped into log processors.
+func printEvents(events []Event) {
+    for _, e := range events {
+        b, err := json.Marshal(e)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error occurred when generating json: %s\n", err.Error())
+            continue
+        }
+        fmt.Println(string(b))
+    }
+}
+
+
+/*
+watch re-runs query on a ticker and reports only cycles whose result
+changed from the previous one. The first cycle is always reported as a
+baseline. If asJson is true, changes are streamed as newline-delimited
+JSON Events instead of the "std" diff view. count caps the number of
+cycles (0 means unlimited).
+*/
+func watch(dns DnsAddrs, domain string, qtype uint16, proto string, edns Edns0Options, dnssec bool, interval time.Duration, count int, asJson bool) {
+    var prev Answers
+
+    for cycle := 0; count == 0 || cycle < count; cycle++ {
+        cur := query(dns, domain, qtype, proto, edns, dnssec)
+
+        if cycle == 0 {
+            if asJson {
+                printEvents(diffEvents(nil, cur))
+            } else {
+                fmt.Printf("%s initial result:\n", time.Now().Format(time.RFC3339))
+                cur.output(dnssec)
+            }
+        } else if !cur.Equal(prev) {
+            events := diffEvents(prev, cur)
+            if asJson {
+                printEvents(events)
+            } else {
+                fmt.Printf("%s changed:\n", time.Now().Format(time.RFC3339))
+                for _, e := range events {
+                    fmt.Printf("  %s (%s): +%v -%v\n", e.Name, e.Address, e.Added, e.Removed)
+                }
+            }
+        }
+
+        prev = cur
+
+        if count != 0 && cycle+1 >= count {
+            break
+        }
+        time.Sleep(interval)
+    }
 }
 
 
@@ -174,12 +889,33 @@ func in(ip string, ips []string) bool {
 }
 
 
+// secStatus classifies a resolver's DNSSEC outcome as dig-style
+// secure/insecure/bogus/indeterminate.
+func secStatus(a Answer) string {
+    if a.Error != nil {
+        return "-"
+    }
+    if a.DnssecError != "" {
+        return "bogus"
+    }
+    if a.Validated {
+        return "secure"
+    }
+    if a.AD {
+        // The resolver claims the answer validated, but our own check
+        // didn't complete (no RRSIG to verify, or no matching DS).
+        return "indeterminate"
+    }
+    return "insecure"
+}
+
+
 // Output the query results.
-func (a Answers) output() {
+func (a Answers) output(dnssec bool) {
 
-    allip := a.allIP()
+    allrec := a.allRecords()
 
-    resultNum := len(allip)
+    resultNum := len(allrec)
     if resultNum == 0 {
         resultNum = 1 // leave room for displaying error
     }
@@ -192,7 +928,7 @@ func (a Answers) output() {
     head := make([]string, len(a) * 2)
 
     /*
-    A domain's IPs queried from different name servers. Example:
+    A domain's records queried from different name servers. Example:
     11.11.11.11  Timout       -            -
     11.11.11.12  -            11.11.11.12  -
     -            -            11.11.11.13  -
@@ -200,7 +936,7 @@ func (a Answers) output() {
     */
     ip := make([]string, len(a) * resultNum)
 
-    // Fill ip with "-" 
+    // Fill ip with "-"
     for i, _ := range ip {
         ip[i] = "-"
     }
@@ -211,9 +947,9 @@ func (a Answers) output() {
         head[i+len(a)]  = item.Address
 
         if item.Error == nil {
-            for j:=0; j<len(allip); j++ {
-                if in(allip[j], item.IP) {
-                    ip[j * len(a) + i] = allip[j]
+            for j:=0; j<len(allrec); j++ {
+                if in(allrec[j], item.Records) {
+                    ip[j * len(a) + i] = allrec[j]
                 }
             }
         } else {
@@ -239,15 +975,39 @@ func (a Answers) output() {
             fmt.Println()
         }
     }
+
+    // Show which anycast POP answered, if any resolver returned an NSID.
+    for _, item := range a {
+        if item.NSID != "" {
+            fmt.Println()
+            for _, item := range a {
+                if item.NSID != "" {
+                    fmt.Printf("NSID %s: %s\n", item.Name, item.NSID)
+                }
+            }
+            break
+        }
+    }
+
+    // Show each resolver's DNSSEC validation status.
+    if dnssec {
+        fmt.Println()
+        for _, item := range a {
+            fmt.Printf("SEC %-17s %s\n", item.Name, secStatus(item))
+            if item.DnssecError != "" {
+                fmt.Printf("    %s\n", item.DnssecError)
+            }
+        }
+    }
 }
 
 
-// Output all IPs resolved from all nameserver and ignore errors.
+// Output all records resolved from all nameserver and ignore errors.
 func (a Answers) outputNormal() {
 
-    allip := a.allIP()
+    allrec := a.allRecords()
 
-    for _, i := range allip {
+    for _, i := range allrec {
         fmt.Println(i)
     }
 }
@@ -260,7 +1020,12 @@ func (a Answers) outputJson() {
     aj := make(AnswersJson, len(a))
     for j, item := range(a) {
         aj[j].DnsAddr = item.DnsAddr
-        aj[j].IP = item.IP
+        aj[j].Type = item.Type
+        aj[j].Records = item.Records
+        aj[j].NSID = item.NSID
+        aj[j].AD = item.AD
+        aj[j].Validated = item.Validated
+        aj[j].DnssecError = item.DnssecError
         if item.Error != nil {
             aj[j].Error = item.Error.Error()
         }
@@ -328,7 +1093,9 @@ servers. It can show different query results between different name servers.
 This tool is implemented in Go.
 
 Usage:
-    appname [-l <file] [-f <std|json|ip>] [-t] <domain>
+    appname [-l <file] [-f <std|json|ip>] [-type <type>] [-proto <proto>] [-subnet <cidr>] [-nsid] [-dnssec] <domain>
+    appname -watch <interval> [-watch-count <n>] [-f std|json] ... <domain>
+    appname -trace [-type <type>] [-proto tcp|udp] <domain>
     appname [-l <file] -c
     appname -s
     appname -h
@@ -337,8 +1104,23 @@ Usage:
 Options:
     -l, -load <file>            Use <file> instead of default configuration file
     -f, -format <std|json|ip>   Specify the output format
+    -type <type>                Record type to query: A|AAAA|MX|TXT|NS|SOA|CNAME|PTR|CAA (default A)
+    -proto <proto>              Transport to use: udp|tcp|tcp-tls|https (default udp), overridden
+                                per name server by "proto" in the configuration file
+    -subnet <cidr>               Attach an EDNS0 client subnet option, e.g. 1.2.3.0/24, overridden
+                                per name server by "subnet" in the configuration file
+    -nsid                        Request the responder's EDNS0 NSID for every name server; without
+                                it, "nsid": true in the configuration file turns it on per server
+    -dnssec                      Set the DO bit and independently validate the RRSIG/DNSKEY/DS
+                                chain covering the answer, showing secure/insecure/bogus/
+                                indeterminate per resolver
+    -watch <interval>            Re-run the query every <interval> (e.g. 30s) and only report
+                                cycles whose result changed; with "-f json", each change is
+                                streamed as one newline-delimited JSON object
+    -watch-count <n>             Stop "-watch" after <n> cycles (default 0, unlimited)
+    -trace                       Resolve iteratively from the root servers, like "dig +trace",
+                                instead of querying the configured name servers in parallel
     -s, -sample                 Output sample configuration to stdout
-    -t, -tcp                    Use tcp protocol instead of udp
     -c, -config                 Print content of configuration file
     -h, -help                   Show help
     -v, -version                Output version information
@@ -358,7 +1140,7 @@ Configuration file:
 
 Example:
     appname www.example.com
-    appname -l config.json -f json -tcp www.example.com
+    appname -l config.json -f json -proto tcp www.example.com
     appname -l config.json -c
     appname -s > ~/.ipre && appname www.example.com
 
@@ -385,7 +1167,9 @@ j := `[
     {"name": "AliDNS",    "address": "223.5.5.5"        },
     {"name": "114DNS",    "address": "114.114.114.114"  },
     {"name": "Google",    "address": "8.8.8.8"          },
-    {"name": "OpenDNS",   "address": "208.67.222.222"   }
+    {"name": "OpenDNS",   "address": "208.67.222.222"   },
+    {"name": "Cloudflare-DoT", "address": "1.1.1.1", "proto": "tcp-tls", "tls_servername": "cloudflare-dns.com" },
+    {"name": "Google-DoH",     "address": "dns.google",  "proto": "https" }
 ]`
 
 fmt.Println(j)
@@ -492,17 +1276,24 @@ func getDefaultConfig() (DnsAddrs, string, error) {
 
 func main() {
 
-    var configfile, format string
-    var sample, useTcp, printconf, help, showver bool
+    var configfile, format, qtypeName, proto, subnet, watchStr string
+    var sample, printconf, help, showver, nsid, doTrace, dnssec bool
+    var watchCount int
 
     flag.StringVar(&configfile, "l", "", "-l <file>")
     flag.StringVar(&configfile, "load", "", "-load <file>")
     flag.StringVar(&format, "f", "", "-f <std|json|ip>")
     flag.StringVar(&format, "format", "", "-format <std|json|ip>")
+    flag.StringVar(&qtypeName, "type", "A", "-type <A|AAAA|MX|TXT|NS|SOA|CNAME|PTR|CAA>")
+    flag.StringVar(&proto, "proto", "udp", "-proto <udp|tcp|tcp-tls|https>")
+    flag.StringVar(&subnet, "subnet", "", "-subnet <cidr>")
+    flag.BoolVar(&nsid, "nsid", false, "-nsid")
+    flag.BoolVar(&doTrace, "trace", false, "-trace")
+    flag.BoolVar(&dnssec, "dnssec", false, "-dnssec")
+    flag.StringVar(&watchStr, "watch", "", "-watch <interval>")
+    flag.IntVar(&watchCount, "watch-count", 0, "-watch-count <n>")
     flag.BoolVar(&sample, "s", false, "-s")
     flag.BoolVar(&sample, "sample", false, "-sample")
-    flag.BoolVar(&useTcp, "t", false, "-t")
-    flag.BoolVar(&useTcp, "tcp", false, "-tcp")
     flag.BoolVar(&printconf, "c", false, "-c")
     flag.BoolVar(&printconf, "config", false, "-config")
     flag.BoolVar(&help, "help", false, "-help")
@@ -541,6 +1332,51 @@ func main() {
         os.Exit(1)
     }
 
+    qtype, ok := recordTypes[strings.ToUpper(qtypeName)]
+    if !ok {
+        fmt.Fprintf(os.Stderr, "Record type %s is not correct, use '-h' for help\n", qtypeName)
+        os.Exit(1)
+    }
+
+    if proto != "udp" && proto != "tcp" && proto != "tcp-tls" && proto != "https" {
+        fmt.Fprintf(os.Stderr, "Proto %s is not correct, use '-h' for help\n", proto)
+        os.Exit(1)
+    }
+
+    if subnet != "" {
+        if _, _, err := net.ParseCIDR(subnet); err != nil {
+            fmt.Fprintf(os.Stderr, "Subnet %s is not correct, use '-h' for help\n", subnet)
+            os.Exit(1)
+        }
+    }
+
+    var watchInterval time.Duration
+    if watchStr != "" {
+        var err error
+        watchInterval, err = time.ParseDuration(watchStr)
+        if err != nil || watchInterval <= 0 {
+            fmt.Fprintf(os.Stderr, "Watch interval %s is not correct, use '-h' for help\n", watchStr)
+            os.Exit(1)
+        }
+    }
+
+    if watchCount < 0 {
+        fmt.Fprintf(os.Stderr, "Watch count %d is not correct, use '-h' for help\n", watchCount)
+        os.Exit(1)
+    }
+
+    if doTrace {
+        if len(flag.Args()) == 0 {
+            fmt.Fprintln(os.Stderr, "Please input a domain for querying, use '-h' for help")
+            os.Exit(1)
+        }
+        if err := trace(flag.Args()[0], qtype, proto); err != nil {
+            fmt.Fprintln(os.Stderr, err.Error())
+            os.Exit(1)
+        }
+        os.Exit(0)
+    }
+
     var conf DnsAddrs
     var confpath string
     var err error
@@ -577,16 +1413,14 @@ func main() {
         os.Exit(1)
     }
 
-    var net string
-    if useTcp {
-        net = "tcp"
-    } else {
-        net = "udp"
+    if watchStr != "" {
+        watch(conf, flag.Args()[0], qtype, proto, Edns0Options{Subnet: subnet, Nsid: nsid}, dnssec, watchInterval, watchCount, format == "json")
+        os.Exit(0)
     }
-    
-    result := query(conf, flag.Args()[0], net)
+
+    result := query(conf, flag.Args()[0], qtype, proto, Edns0Options{Subnet: subnet, Nsid: nsid}, dnssec)
     if format == "std" {
-        result.output()
+        result.output(dnssec)
     } else if format == "json" {
         result.outputJson()
     } else if format == "ip" {